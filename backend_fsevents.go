@@ -4,12 +4,39 @@
 package fsnotify
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/eXotech-code/fsevents"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
 )
 
+// Common errors that can be reported by a watcher
+var (
+	ErrNonExistentWatch = errors.New("can't remove non-existent watcher")
+
+	// ErrEventOverflow is sent on Errors when FSEvents reports that it
+	// dropped events (the MustScanSubDirs, UserDropped or KernelDropped
+	// flags); some changes under the watched paths may have been missed and
+	// callers should rescan them.
+	ErrEventOverflow = errors.New("fsnotify queue overflow")
+
+	// ErrRootChanged is sent on Errors when a watched root (or one of its
+	// parent directories) was renamed or removed; the watch keeps running
+	// but callers should re-establish their state for the affected root.
+	ErrRootChanged = errors.New("fsnotify: watched root changed")
+
+	// ErrRateLimited is sent on Errors (at most once per Event.Name per
+	// window) when WithRateLimit is coalescing events for a path because it
+	// exceeded the configured rate.
+	ErrRateLimited = errors.New("fsnotify: event rate limited")
+)
+
 type Watcher struct {
 	// Events sends the filesystem change events.
 	//
@@ -59,11 +86,86 @@ type Watcher struct {
 	Errors chan error
 
 	done               chan struct{}
-	watches            map[string]int // Watched file descriptors (key: path).
+	watches            map[string]int      // Watched paths (key: path; the value is always 1).
+	recursive          map[string]struct{} // Roots added with AddRecursive (key: path, subset of watches).
 	eventStream        *fsevents.EventStream
 	eventStreamStarted bool
+	deviceMountPoint   string // Absolute mount point of eventStream.Device; see absolutePath.
 	isClosed           bool
 	mu                 sync.Mutex
+
+	// debounce and coalesceOps configure the optional coalescing layer set
+	// up by WithDebounce and WithCoalesce; debounce <= 0 disables it.
+	debounce    time.Duration
+	coalesceOps Op
+	coalesceSet bool
+	coalescer   *coalescer
+
+	// rate is set by WithRateLimit; nil means rate limiting is disabled.
+	rate *rateLimiter
+}
+
+// Option configures optional behaviour of a [Watcher] created with
+// [NewWatcherWithOptions].
+type Option func(*Watcher)
+
+// WithDebounce enables coalescing: events for the same Event.Name that
+// arrive within d of each other are merged into a single Event (with their
+// Op bits OR'd together) instead of being sent to Events immediately. The
+// merged event is flushed once d passes without a new event for that name,
+// or when Close is called.
+//
+// This is the dedup pattern the doc-comment on [Watcher.Events] points
+// users at; WithDebounce does it inside the watcher so it works the same
+// way regardless of backend. By default every operation is eligible; use
+// [WithCoalesce] to restrict that to specific operations.
+//
+// FSEvents already coalesces events up to the event stream's own Latency
+// setting; d is only useful set above that.
+func WithDebounce(d time.Duration) Option {
+	return func(w *Watcher) { w.debounce = d }
+}
+
+// WithCoalesce restricts the coalescing enabled by [WithDebounce] to the
+// given operations; events whose Op isn't in ops are sent immediately.
+func WithCoalesce(ops Op) Option {
+	return func(w *Watcher) {
+		w.coalesceOps = ops
+		w.coalesceSet = true
+	}
+}
+
+// WithLatency sets the FSEvents coalescing latency: the time the service
+// waits after hearing about an event from the kernel before passing it
+// along, per fsevents.EventStream.Latency. Lower values suit latency
+// sensitive callers (e.g. log tailers); higher values trade responsiveness
+// for fewer, larger batches and suit batch-oriented callers (e.g. indexers).
+// The default, matching [NewWatcher], is 500ms.
+func WithLatency(d time.Duration) Option {
+	return func(w *Watcher) { w.eventStream.Latency = d }
+}
+
+// WithRateLimit installs a leaky-bucket limiter, keyed by Event.Name,
+// between the FSEvents callback and Events: at most events events per per
+// (plus a burst-sized allowance) are forwarded for a given name, and excess
+// events are merged (Op bits OR'd together) into a single event that is
+// flushed later, as described below.
+//
+// Without this, a burst on one noisy path (build systems, rsync, npm
+// install) against the unbuffered Events channel stalls the FSEvents
+// callback behind a slow consumer, which in turn stalls every other watched
+// path. [ErrRateLimited] is sent on Errors at most once per name per window
+// so observability tooling can see the pressure.
+//
+// A merged event is flushed automatically once per has passed without another
+// event for that name being held back (events forwarded in the meantime do
+// not delay it), much like [WithDebounce]; the watcher does not hold events
+// indefinitely. [Watcher.Close] also flushes any event still pending.
+func WithRateLimit(events int, per time.Duration, burst int) Option {
+	return func(w *Watcher) {
+		w.rate = newRateLimiter(events, per, burst, w.emit)
+		go w.rate.sweep(w.done)
+	}
 }
 
 // Returns true if the event was sent, or false if watcher is closed.
@@ -76,13 +178,49 @@ func (w *Watcher) sendEvent(event Event) bool {
 	}
 }
 
+// Returns true if the error was sent, or false if watcher is closed.
+func (w *Watcher) sendError(err error) bool {
+	select {
+	case w.Errors <- err:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// trySendError sends err on Errors without blocking, dropping it if nothing
+// is currently receiving; used where stalling the caller (e.g. the FSEvents
+// callback, via dispatch) on a slow Errors consumer would defeat the point
+// of the code sending it.
+func (w *Watcher) trySendError(err error) {
+	select {
+	case w.Errors <- err:
+	default:
+	}
+}
+
 // Converts an fsevents.Event value to a fsnotify.Event value
 // in order to get a portable event value that has the same
 // meaing accross platforms.
-func getPortableEvent(e fsevents.Event) (converted Event) {
+//
+// dropped reports that FSEvents coalesced events hierarchically and lost
+// some detail (MustScanSubDirs, optionally with UserDropped or
+// KernelDropped); rootChanged reports that a watched root (or a directory
+// along its path) was renamed or removed (RootChanged, only sent when the
+// stream was created with the WatchRoot flag).
+func getPortableEvent(e fsevents.Event) (converted Event, dropped, rootChanged bool) {
 	converted.Name = e.Path
 	f := e.Flags
 
+	if f&fsevents.MustScanSubDirs == fsevents.MustScanSubDirs ||
+		f&fsevents.UserDropped == fsevents.UserDropped ||
+		f&fsevents.KernelDropped == fsevents.KernelDropped {
+		dropped = true
+	}
+	if f&fsevents.RootChanged == fsevents.RootChanged {
+		rootChanged = true
+	}
+
 	if f&fsevents.ItemCreated == fsevents.ItemCreated {
 		converted.Op |= Create
 	}
@@ -102,6 +240,58 @@ func getPortableEvent(e fsevents.Event) (converted Event) {
 	return
 }
 
+// shouldEmit reports whether an FSEvents callback for name should be
+// forwarded to Events.
+//
+// FSEvents has no notion of "watch this path only, not its subtree": every
+// registered path delivers events for its whole hierarchy. A plain [Add]
+// only asked for name itself and, for a directory, its direct entries, so
+// deeper descendant events are filtered out here; [AddRecursive] roots are
+// left unfiltered so their whole subtree comes through.
+func (w *Watcher) shouldEmit(name string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.watches[name]; ok {
+		return true
+	}
+	if _, ok := w.watches[filepath.Dir(name)]; ok {
+		return true
+	}
+	_, ok := w.recursiveRootFor(name)
+	return ok
+}
+
+// emit sends e, or coalesces it with a pending merged event for the same
+// name when WithDebounce is enabled.
+func (w *Watcher) emit(e Event) {
+	if w.debounce <= 0 || (w.coalesceSet && !w.coalesceOps.Has(e.Op)) {
+		w.sendEvent(e)
+		return
+	}
+
+	w.coalescer.submit(e, w.debounce)
+}
+
+// dispatch runs e through the optional rate limiter (WithRateLimit) before
+// handing it to emit, which applies debounce coalescing (WithDebounce) and
+// finally sendEvent. A rate-limited event isn't lost: the limiter merges it
+// and flushes it later through w.emit itself, once per has passed or on
+// Close.
+func (w *Watcher) dispatch(e Event) {
+	if w.rate != nil {
+		forward, notify := w.rate.allow(e)
+		if notify {
+			w.trySendError(ErrRateLimited)
+		}
+		if !forward {
+			return
+		}
+	}
+
+	w.emit(e)
+}
+
 func (w *Watcher) readEvents() {
 	defer func() {
 		close(w.Events)
@@ -111,7 +301,20 @@ func (w *Watcher) readEvents() {
 	ec := w.eventStream.Events
 	for eventArr := range ec {
 		for _, e := range eventArr {
-			w.sendEvent(getPortableEvent(e))
+			event, dropped, rootChanged := getPortableEvent(e)
+			event.Name = w.absolutePath(event.Name)
+			if dropped {
+				w.sendError(ErrEventOverflow)
+				continue
+			}
+			if rootChanged {
+				w.sendError(fmt.Errorf("%w: %s", ErrRootChanged, event.Name))
+				continue
+			}
+			if !w.shouldEmit(event.Name) {
+				continue
+			}
+			w.dispatch(event)
 		}
 	}
 }
@@ -124,34 +327,228 @@ func getDeviceIdForPath(path string) (int32, error) {
 	return stat.Dev, nil
 }
 
-func (w *Watcher) Add(name string) (err error) {
+// mountPointForDevice returns the absolute path that device dev is mounted
+// on, by checking every mounted filesystem's root against dev.
+//
+// This is needed because eventStream.Device is always set (see addPath),
+// which makes FSEvents create the stream with EventStreamCreateRelativeToDevice;
+// per fsevents.Event.Path's doc-comment, that means event paths are
+// delivered relative to this mount point, not as the absolute paths passed
+// to Add.
+func mountPointForDevice(dev int32) (string, error) {
+	n, err := unix.Getfsstat(nil, unix.MNT_NOWAIT)
+	if err != nil {
+		return "", err
+	}
+
+	mounts := make([]unix.Statfs_t, n)
+	if _, err := unix.Getfsstat(mounts, unix.MNT_NOWAIT); err != nil {
+		return "", err
+	}
+
+	for _, m := range mounts {
+		mnt := unix.ByteSliceToString(m.Mntonname[:])
+		var stat syscall.Stat_t
+		if err := syscall.Stat(mnt, &stat); err != nil {
+			continue
+		}
+		if stat.Dev == dev {
+			return mnt, nil
+		}
+	}
+
+	return "", fmt.Errorf("fsnotify: no mount point found for device %d", dev)
+}
+
+// addPath registers name with the event stream. w.mu must be held.
+func (w *Watcher) addPath(name string) error {
 	dev, err := getDeviceIdForPath(name)
 	if err != nil {
 		return err
 	}
 
 	w.eventStream.Paths = append(w.eventStream.Paths, name)
+	w.watches[name] = 1
 	if !w.eventStreamStarted {
+		mount, err := mountPointForDevice(dev)
+		if err != nil {
+			return err
+		}
+		w.deviceMountPoint = mount
 		w.eventStream.Device = dev
 		w.eventStream.Start()
+		w.eventStreamStarted = true
 	} else {
 		w.eventStream.Restart()
 	}
 
-	return
+	return nil
 }
 
-func (w *Watcher) Close() error {
+// absolutePath converts a device-relative path as delivered in
+// fsevents.Event.Path back into the absolute form callers passed to Add.
+func (w *Watcher) absolutePath(name string) string {
+	w.mu.Lock()
+	mount := w.deviceMountPoint
+	w.mu.Unlock()
+
+	if mount == "" {
+		return name
+	}
+	return filepath.Join(mount, name)
+}
+
+func (w *Watcher) Add(name string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.watches[name]; ok {
+		return nil
+	}
+
+	return w.addPath(name)
+}
+
+// AddRecursive starts monitoring the directory tree rooted at name.
+//
+// FSEvents already reports changes anywhere below a watched path, so beyond
+// what [Add] does there is nothing extra to register with the event stream
+// here: this also records name as a recursive root so readEvents lets events
+// for all its descendants through, where for a plain, non-recursive [Add] it
+// lets through only the path itself and its direct entries. Use [Recursive] to check whether the
+// running backend supports this before relying on it.
+func (w *Watcher) AddRecursive(name string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.watches[name]; ok {
+		w.recursive[name] = struct{}{}
+		return nil
+	}
+
+	if err := w.addPath(name); err != nil {
+		return err
+	}
+	w.recursive[name] = struct{}{}
+	return nil
+}
+
+// Recursive reports whether this watcher backend can watch whole directory
+// trees natively with [AddRecursive], rather than callers having to walk the
+// tree and call [Add] on every directory themselves.
+//
+// FSEvents always delivers events for a watched path's entire subtree, so on
+// Darwin this is always true.
+func (w *Watcher) Recursive() bool { return true }
+
+// recursiveRootFor reports whether name falls within a directory tree
+// registered with [AddRecursive], and if so returns that root.
+//
+// w.mu must be held.
+func (w *Watcher) recursiveRootFor(name string) (string, bool) {
+	for root := range w.recursive {
+		if isStrictDescendant(root, name) {
+			return root, true
+		}
+	}
+	return "", false
+}
+
+// Remove stops monitoring the path for changes.
+//
+// Removing a path that has not yet been added returns [ErrNonExistentWatch].
+// Removing a path added with [AddRecursive] must be done with
+// [RemoveRecursive] instead; removing a descendant of a recursive root that
+// was never itself added also returns [ErrNonExistentWatch], naming the root
+// that needs to be passed to RemoveRecursive.
+func (w *Watcher) Remove(name string) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if _, ok := w.recursive[name]; ok {
+		return fmt.Errorf("%s was added with AddRecursive, use RemoveRecursive instead", name)
+	}
+
+	if _, ok := w.watches[name]; !ok {
+		if root, ok := w.recursiveRootFor(name); ok {
+			return fmt.Errorf("%w: %s is only watched as part of the recursive root %s; use RemoveRecursive(%q)",
+				ErrNonExistentWatch, name, root, root)
+		}
+		return fmt.Errorf("%w: %s", ErrNonExistentWatch, name)
+	}
+	delete(w.watches, name)
+
+	paths := w.eventStream.Paths[:0]
+	for _, p := range w.eventStream.Paths {
+		if p != name {
+			paths = append(paths, p)
+		}
+	}
+	w.eventStream.Paths = paths
+
+	if len(w.eventStream.Paths) == 0 {
+		w.eventStream.Stop()
+		w.eventStreamStarted = false
+		return nil
+	}
+
+	w.eventStream.Restart()
+	return nil
+}
+
+// RemoveRecursive stops monitoring the directory tree rooted at name.
+//
+// Removing a root that was not added with [AddRecursive] returns
+// [ErrNonExistentWatch].
+func (w *Watcher) RemoveRecursive(name string) error {
+	w.mu.Lock()
+	if _, ok := w.recursive[name]; !ok {
+		w.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrNonExistentWatch, name)
+	}
+	delete(w.recursive, name)
+	w.mu.Unlock()
+
+	return w.Remove(name)
+}
+
+// WatchList returns all paths added with [Add] (and are not yet removed).
+func (w *Watcher) WatchList() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	entries := make([]string, 0, len(w.watches))
+	for name := range w.watches {
+		entries = append(entries, name)
+	}
+
+	return entries
+}
+
+// Close stops watching all paths and releases resources used for the
+// watcher.
+func (w *Watcher) Close() error {
+	w.mu.Lock()
 	if w.isClosed {
+		w.mu.Unlock()
 		return nil
 	}
 	w.isClosed = true
 
 	w.eventStream.Stop()
 	close(w.done)
+	w.mu.Unlock()
+
+	// Flush any coalesced/rate-limited event still pending with w.mu
+	// released and w.done already closed: sendEvent's write to Events races
+	// against <-w.done, so this is a best-effort delivery rather than a
+	// blocking one, and other methods aren't stuck waiting on w.mu behind it.
+	// The rate limiter goes first: it flushes through emit, which may hand
+	// its events to the coalescer.
+	if w.rate != nil {
+		w.rate.flushAll()
+	}
+	w.coalescer.flushAll()
 
 	return nil
 }
@@ -171,9 +568,90 @@ func NewWatcher() (*Watcher, error) {
 		Errors:      make(chan error),
 		done:        make(chan struct{}),
 		watches:     make(map[string]int),
+		recursive:   make(map[string]struct{}),
 		eventStream: es,
 	}
+	w.coalescer = newCoalescer(w.sendEvent)
 
 	go w.readEvents()
 	return w, nil
 }
+
+// NewWatcherWithOptions creates a new Watcher with the given options
+// applied. See [WithDebounce], [WithCoalesce], [WithLatency] and
+// [WithRateLimit].
+func NewWatcherWithOptions(opts ...Option) (*Watcher, error) {
+	w, err := NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w, nil
+}
+
+// WaitForCreate blocks until path is created, ctx is cancelled, or an error
+// occurs watching for it.
+//
+// It encapsulates the stat-then-watch-then-restat dance needed to avoid the
+// race between the initial check and the watch being established, which
+// downstream users otherwise have to reimplement against the raw [Watcher]
+// every time they need to wait for a path to appear (e.g. a reader polling
+// for the next sequence file written by another process).
+func WaitForCreate(ctx context.Context, path string) error {
+	dir, base := filepath.Dir(path), filepath.Base(path)
+	return WaitForCreateAny(ctx, dir, func(name string) bool { return name == base })
+}
+
+// WaitForCreateAny blocks until a file whose base name satisfies match is
+// created in dir, ctx is cancelled, or an error occurs watching for it.
+//
+// It watches dir with a private Watcher for the duration of the call, so it
+// is safe to use even when nothing else is watching dir.
+func WaitForCreateAny(ctx context.Context, dir string, match func(name string) bool) error {
+	if found, err := dirHasMatch(dir, match); err != nil {
+		return err
+	} else if found {
+		return nil
+	}
+
+	w, err := NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if err := w.Add(dir); err != nil {
+		return err
+	}
+
+	// Re-check now that the watch is established, to close the race
+	// between the check above and Add.
+	if found, err := dirHasMatch(dir, match); err != nil {
+		return err
+	} else if found {
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err, ok := <-w.Errors:
+			if !ok {
+				return errors.New("fsnotify: watcher closed while waiting for create")
+			}
+			return err
+		case e, ok := <-w.Events:
+			if !ok {
+				return errors.New("fsnotify: watcher closed while waiting for create")
+			}
+			if e.Has(Create) && match(filepath.Base(e.Name)) {
+				return nil
+			}
+		}
+	}
+}
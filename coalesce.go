@@ -0,0 +1,84 @@
+package fsnotify
+
+import (
+	"sync"
+	"time"
+)
+
+// coalesceEntry holds the merged event and pending flush timer for a single
+// coalesced name.
+type coalesceEntry struct {
+	event Event
+	timer *time.Timer
+}
+
+// coalescer merges events for the same name that arrive within a window of
+// each other into a single event, used by [WithDebounce]. submit's caller
+// decides whether a given event should be coalesced at all (e.g. via
+// [WithCoalesce]); coalescer only owns the per-name merge and timer
+// mechanics.
+type coalescer struct {
+	mu      sync.Mutex
+	entries map[string]*coalesceEntry
+	onFlush func(Event)
+}
+
+// newCoalescer returns a coalescer that calls onFlush with each merged event
+// once it's due.
+func newCoalescer(onFlush func(Event)) *coalescer {
+	return &coalescer{
+		entries: make(map[string]*coalesceEntry),
+		onFlush: onFlush,
+	}
+}
+
+// submit merges e into the pending event for e.Name, resetting its flush
+// deadline to d; the merged event is flushed once d passes without a new
+// submit for that name.
+func (c *coalescer) submit(e Event, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[e.Name]; ok {
+		entry.event.Op |= e.Op
+		entry.timer.Reset(d)
+		return
+	}
+
+	name := e.Name
+	entry := &coalesceEntry{event: e}
+	entry.timer = time.AfterFunc(d, func() { c.flush(name) })
+	c.entries[name] = entry
+}
+
+// flush sends the merged event pending for name, if any, once its deadline
+// fires.
+func (c *coalescer) flush(name string) {
+	c.mu.Lock()
+	entry, ok := c.entries[name]
+	if ok {
+		delete(c.entries, name)
+	}
+	c.mu.Unlock()
+
+	if ok {
+		c.onFlush(entry.event)
+	}
+}
+
+// flushAll sends every event still pending coalescing, used on Close so a
+// debounced watcher doesn't silently drop its last events.
+func (c *coalescer) flushAll() {
+	c.mu.Lock()
+	pending := make([]Event, 0, len(c.entries))
+	for name, entry := range c.entries {
+		entry.timer.Stop()
+		pending = append(pending, entry.event)
+		delete(c.entries, name)
+	}
+	c.mu.Unlock()
+
+	for _, e := range pending {
+		c.onFlush(e)
+	}
+}
@@ -0,0 +1,40 @@
+package fsnotify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoalescerMergesWithinWindow(t *testing.T) {
+	flushed := make(chan Event, 1)
+	c := newCoalescer(func(e Event) { flushed <- e })
+
+	c.submit(Event{Name: "f", Op: Create}, 50*time.Millisecond)
+	c.submit(Event{Name: "f", Op: Write}, 50*time.Millisecond)
+
+	select {
+	case e := <-flushed:
+		if !e.Has(Create) || !e.Has(Write) {
+			t.Errorf("flushed event = %v, want Create|Write merged", e.Op)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for merged flush")
+	}
+}
+
+func TestCoalescerFlushAllDrainsPending(t *testing.T) {
+	var flushed []Event
+	c := newCoalescer(func(e Event) { flushed = append(flushed, e) })
+
+	c.submit(Event{Name: "a", Op: Create}, time.Hour)
+	c.submit(Event{Name: "b", Op: Remove}, time.Hour)
+
+	c.flushAll()
+
+	if len(flushed) != 2 {
+		t.Fatalf("flushAll() flushed %d events, want 2", len(flushed))
+	}
+	if len(c.entries) != 0 {
+		t.Errorf("flushAll() left %d entries pending, want 0", len(c.entries))
+	}
+}
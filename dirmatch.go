@@ -0,0 +1,18 @@
+package fsnotify
+
+import "os"
+
+// dirHasMatch reports whether dir already contains an entry satisfying
+// match.
+func dirHasMatch(dir string, match func(name string) bool) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if match(entry.Name()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
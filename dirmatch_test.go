@@ -0,0 +1,37 @@
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirHasMatch(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "target.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "other.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := dirHasMatch(dir, func(name string) bool { return name == "target.txt" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Error("dirHasMatch() = false, want true for existing entry")
+	}
+
+	found, err = dirHasMatch(dir, func(name string) bool { return name == "missing.txt" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Error("dirHasMatch() = true, want false for absent entry")
+	}
+
+	if _, err := dirHasMatch(filepath.Join(dir, "nope"), func(string) bool { return true }); err == nil {
+		t.Error("dirHasMatch() on a non-existent dir: want error, got nil")
+	}
+}
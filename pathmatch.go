@@ -0,0 +1,20 @@
+package fsnotify
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// isStrictDescendant reports whether name is a path strictly inside root
+// (not root itself), using lexical path comparison the way filepath.Rel
+// does; it doesn't touch the filesystem.
+func isStrictDescendant(root, name string) bool {
+	if root == name {
+		return false
+	}
+	rel, err := filepath.Rel(root, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	return true
+}
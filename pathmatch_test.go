@@ -0,0 +1,23 @@
+package fsnotify
+
+import "testing"
+
+func TestIsStrictDescendant(t *testing.T) {
+	tests := []struct {
+		root, name string
+		want       bool
+	}{
+		{"/tmp/watch", "/tmp/watch/file.txt", true},
+		{"/tmp/watch", "/tmp/watch/sub/file.txt", true},
+		{"/tmp/watch", "/tmp/watch", false},
+		{"/tmp/watch", "/tmp/watcher/file.txt", false},
+		{"/tmp/watch", "/tmp/other/file.txt", false},
+		{"/tmp/watch", "/tmp", false},
+	}
+
+	for _, tt := range tests {
+		if got := isStrictDescendant(tt.root, tt.name); got != tt.want {
+			t.Errorf("isStrictDescendant(%q, %q) = %v, want %v", tt.root, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package fsnotify
+
+import (
+	"sync"
+	"time"
+)
+
+// rateBucket is the per-name token bucket state kept by rateLimiter.
+type rateBucket struct {
+	tokens float64
+	last   time.Time // Also doubles as "last seen", used by sweep to find idle buckets.
+
+	// pending holds an event merged from callbacks that arrived while the
+	// bucket was empty, and timer is the deadline that flushes it; both are
+	// nil when nothing is waiting.
+	pending *Event
+	timer   *time.Timer
+
+	// notified tracks whether ErrRateLimited has already been sent for the
+	// window this bucket is currently rate limited in, so dispatch sends it
+	// at most once per name per window rather than once per suppressed event.
+	notified bool
+}
+
+// rateLimiter is a leaky-bucket limiter keyed by Event.Name, used by
+// [WithRateLimit]. Events within the configured rate are forwarded
+// immediately by allow; events over the limit are merged into the bucket's
+// pending event, which is flushed via onFlush once per has passed without
+// another event for that name being held back, or when flushAll is called on
+// Close.
+type rateLimiter struct {
+	mu      sync.Mutex
+	events  int
+	per     time.Duration
+	burst   int
+	buckets map[string]*rateBucket
+	onFlush func(Event)
+}
+
+// newRateLimiter builds a rateLimiter allowing events events per per (plus a
+// burst-sized allowance) for each name, calling onFlush with any event that
+// was merged while rate limited once it's due to be flushed.
+func newRateLimiter(events int, per time.Duration, burst int, onFlush func(Event)) *rateLimiter {
+	return &rateLimiter{
+		events:  events,
+		per:     per,
+		burst:   burst,
+		buckets: make(map[string]*rateBucket),
+		onFlush: onFlush,
+	}
+}
+
+// refillRate is the number of tokens a bucket gains per second.
+func (r *rateLimiter) refillRate() float64 {
+	return float64(r.events) / r.per.Seconds()
+}
+
+// allow reports whether e should be forwarded immediately (forward), and
+// whether the caller should emit ErrRateLimited for it (notify, sent at
+// most once per name per window). If forward is false, e is merged into the
+// pending event for e.Name and a flush is scheduled.
+func (r *rateLimiter) allow(e Event) (forward, notify bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	b, ok := r.buckets[e.Name]
+	if !ok {
+		b = &rateBucket{tokens: float64(r.burst), last: now}
+		r.buckets[e.Name] = b
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * r.refillRate()
+		if b.tokens > float64(r.burst) {
+			b.tokens = float64(r.burst)
+		}
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.notified = false
+		return true, false
+	}
+
+	if b.pending != nil {
+		b.pending.Op |= e.Op
+	} else {
+		merged := e
+		b.pending = &merged
+	}
+	name := e.Name
+	if b.timer != nil {
+		b.timer.Reset(r.per)
+	} else {
+		b.timer = time.AfterFunc(r.per, func() { r.flush(name) })
+	}
+
+	notify = !b.notified
+	b.notified = true
+	return false, notify
+}
+
+// flush sends the event pending for name, if any, once its deadline fires.
+func (r *rateLimiter) flush(name string) {
+	r.mu.Lock()
+	b, ok := r.buckets[name]
+	var pending Event
+	if ok && b.pending != nil {
+		pending = *b.pending
+		b.pending = nil
+		b.timer = nil
+		b.notified = false
+	} else {
+		ok = false
+	}
+	r.mu.Unlock()
+
+	if ok {
+		r.onFlush(pending)
+	}
+}
+
+// flushAll sends every event still pending across all buckets, used on
+// Close so a rate-limited watcher doesn't silently drop its last events.
+func (r *rateLimiter) flushAll() {
+	r.mu.Lock()
+	pending := make([]Event, 0, len(r.buckets))
+	for _, b := range r.buckets {
+		if b.timer != nil {
+			b.timer.Stop()
+			b.timer = nil
+		}
+		if b.pending != nil {
+			pending = append(pending, *b.pending)
+			b.pending = nil
+			b.notified = false
+		}
+	}
+	r.mu.Unlock()
+
+	for _, e := range pending {
+		r.onFlush(e)
+	}
+}
+
+// sweep periodically drops buckets that haven't seen an event in over per
+// and have nothing pending, until done is closed, so long-lived watchers
+// don't accumulate one bucket per name forever.
+//
+// This must be time-based, not token-based: tokens only refill as a
+// side-effect of allow(), which an idle name by definition stops calling, so
+// a bucket's tokens never reach burst again on their own once spent.
+func (r *rateLimiter) sweep(done <-chan struct{}) {
+	t := time.NewTicker(r.per)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			now := time.Now()
+			r.mu.Lock()
+			for name, b := range r.buckets {
+				if b.pending == nil && now.Sub(b.last) >= r.per {
+					delete(r.buckets, name)
+				}
+			}
+			r.mu.Unlock()
+		case <-done:
+			return
+		}
+	}
+}
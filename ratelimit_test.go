@@ -0,0 +1,98 @@
+package fsnotify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllowsWithinBurst(t *testing.T) {
+	r := newRateLimiter(1, time.Second, 2, func(Event) {})
+
+	if forward, _ := r.allow(Event{Name: "f", Op: Write}); !forward {
+		t.Error("allow() forward = false for first event within burst, want true")
+	}
+	if forward, _ := r.allow(Event{Name: "f", Op: Write}); !forward {
+		t.Error("allow() forward = false for second event within burst, want true")
+	}
+	if forward, _ := r.allow(Event{Name: "f", Op: Write}); forward {
+		t.Error("allow() forward = true once burst is exhausted, want false")
+	}
+}
+
+func TestRateLimiterNotifiesOncePerWindow(t *testing.T) {
+	r := newRateLimiter(1, time.Hour, 1, func(Event) {})
+
+	r.allow(Event{Name: "f", Op: Write})
+	_, notify := r.allow(Event{Name: "f", Op: Write})
+	if !notify {
+		t.Error("allow() notify = false for the first suppressed event, want true")
+	}
+	_, notify = r.allow(Event{Name: "f", Op: Write})
+	if notify {
+		t.Error("allow() notify = true again within the same window, want false")
+	}
+
+	r.flushAll()
+
+	_, notify = r.allow(Event{Name: "f", Op: Write})
+	if !notify {
+		t.Error("allow() notify = false for the first suppressed event of a new window, want true")
+	}
+}
+
+func TestRateLimiterFlushesPendingAfterIdle(t *testing.T) {
+	flushed := make(chan Event, 1)
+	r := newRateLimiter(1, 30*time.Millisecond, 1, func(e Event) { flushed <- e })
+
+	r.allow(Event{Name: "f", Op: Create})
+	if forward, _ := r.allow(Event{Name: "f", Op: Write}); forward {
+		t.Fatal("allow() forward = true, want false once the burst is spent")
+	}
+
+	select {
+	case e := <-flushed:
+		if !e.Has(Write) {
+			t.Errorf("flushed event op = %v, want Write", e.Op)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for idle flush")
+	}
+}
+
+func TestRateLimiterFlushAllDrainsPending(t *testing.T) {
+	var flushed []Event
+	r := newRateLimiter(1, time.Hour, 1, func(e Event) { flushed = append(flushed, e) })
+
+	r.allow(Event{Name: "f", Op: Create})
+	r.allow(Event{Name: "f", Op: Write})
+
+	r.flushAll()
+
+	if len(flushed) != 1 || !flushed[0].Has(Write) {
+		t.Fatalf("flushAll() flushed %v, want one event with Write", flushed)
+	}
+	if r.buckets["f"].pending != nil {
+		t.Error("flushAll() left a pending event behind")
+	}
+}
+
+func TestRateLimiterSweepReclaimsIdleBuckets(t *testing.T) {
+	r := newRateLimiter(1, 10*time.Millisecond, 1, func(Event) {})
+	r.allow(Event{Name: "f", Op: Create})
+
+	done := make(chan struct{})
+	go r.sweep(done)
+	defer close(done)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		_, ok := r.buckets["f"]
+		r.mu.Unlock()
+		if !ok {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("sweep() never reclaimed the idle bucket")
+}